@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// HandlePeaks serves the downsampled min/max waveform for a recording, so
+// edit.mustache can draw it without shipping the whole base64 WAV to the
+// browser.
+func HandlePeaks(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/peaks/")
+	if err := validateName(name); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if _, ok, err := CurrentMeta.Get(name); err != nil || !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	content, err := ioutil.ReadFile(peaksPath(name))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+// HandleAPIList answers /api/list?q=&tag=&sort= with the JSON the homepage
+// needs to render a searchable, sortable table of recordings, rather than
+// shelling every recording name into a mustache loop.
+func HandleAPIList(w http.ResponseWriter, r *http.Request) {
+	query := strings.ToLower(r.URL.Query().Get("q"))
+	tag := r.URL.Query().Get("tag")
+	sortBy := r.URL.Query().Get("sort")
+
+	names, err := CurrentMeta.List()
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	entries := make([]IndexEntry, 0, len(names))
+	for _, name := range names {
+		cut, ok, err := CurrentMeta.Get(name)
+		if err != nil || !ok {
+			continue
+		}
+		entry := IndexEntry{Name: name, Tags: cut.Tags, Notes: cut.Notes}
+		if cached, ok := recordingIndex.Load(name); ok {
+			indexed := cached.(IndexEntry)
+			entry.Duration = indexed.Duration
+			entry.SampleRate = indexed.SampleRate
+			entry.Channels = indexed.Channels
+			entry.Size = indexed.Size
+		}
+
+		if query != "" && !strings.Contains(strings.ToLower(name), query) &&
+			!strings.Contains(strings.ToLower(cut.Notes), query) {
+			continue
+		}
+		if tag != "" && !hasTag(entry.Tags, tag) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	switch sortBy {
+	case "duration":
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Duration < entries[j].Duration })
+	case "size":
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Size < entries[j].Size })
+	default:
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+func hasTag(tags []string, want string) bool {
+	for _, tag := range tags {
+		if tag == want {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleAPIMeta lets the owner (or an admin) update a recording's tags and
+// notes without re-uploading the audio.
+func HandleAPIMeta(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Expected POST", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/api/meta/")
+	if err := validateName(name); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	lock := lockForName(name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	cut, ok, err := CurrentMeta.Get(name)
+	if err != nil || !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !isOwnerOrAdmin(r, cut.Owner) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	sess := SessionFromRequest(r)
+	if sess == nil || !CheckCSRF(r, sess) {
+		http.Error(w, "Bad CSRF token", http.StatusForbidden)
+		return
+	}
+
+	var update struct {
+		Tags  []string `json:"tags"`
+		Notes string   `json:"notes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	cut.Tags = update.Tags
+	cut.Notes = update.Notes
+	if err := CurrentMeta.Set(name, cut); err != nil {
+		log.Print("Failed to save metadata for ", name, ": ", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Write([]byte("true"))
+}
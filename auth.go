@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Role determines what a user is allowed to do.
+type Role string
+
+const (
+	RoleAdmin  Role = "admin"
+	RoleUser   Role = "user"
+	RoleViewer Role = "viewer"
+)
+
+// UserRecord is how a user is stored in users.json.
+type UserRecord struct {
+	PasswordHash string `json:"password_hash"`
+	Role         Role   `json:"role"`
+}
+
+var UsersPath string
+var UsersLock sync.Mutex
+var Users map[string]UserRecord = map[string]UserRecord{}
+
+// LoadUsers reads users.json into Users. A missing file just means nobody
+// can log in yet; it is not treated as a fatal error.
+func LoadUsers() {
+	content, err := ioutil.ReadFile(UsersPath)
+	if err != nil {
+		return
+	}
+	json.Unmarshal(content, &Users)
+}
+
+// Session tracks a logged-in user behind a cookie, along with the CSRF
+// token that must accompany any state-changing request made with it.
+type Session struct {
+	User      string
+	Role      Role
+	CSRFToken string
+	Expires   time.Time
+}
+
+var SessionsLock sync.Mutex
+var Sessions map[string]*Session = map[string]*Session{}
+
+const SessionCookieName = "recordathon_session"
+const SessionLifetime = 7 * 24 * time.Hour
+
+func randomToken() string {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// CreateSession starts a new session for user and drops a cookie on w.
+func CreateSession(w http.ResponseWriter, user string, role Role) *Session {
+	sess := &Session{
+		User:      user,
+		Role:      role,
+		CSRFToken: randomToken(),
+		Expires:   time.Now().Add(SessionLifetime),
+	}
+	token := randomToken()
+
+	SessionsLock.Lock()
+	Sessions[token] = sess
+	SessionsLock.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  sess.Expires,
+		HttpOnly: true,
+	})
+	return sess
+}
+
+// SessionFromRequest looks up the session attached to r's cookie, if any.
+func SessionFromRequest(r *http.Request) *Session {
+	cookie, err := r.Cookie(SessionCookieName)
+	if err != nil {
+		return nil
+	}
+
+	SessionsLock.Lock()
+	defer SessionsLock.Unlock()
+
+	sess, ok := Sessions[cookie.Value]
+	if !ok || time.Now().After(sess.Expires) {
+		delete(Sessions, cookie.Value)
+		return nil
+	}
+	return sess
+}
+
+// CheckCSRF verifies the X-CSRF-Token header (or csrf_token form value)
+// against the session attached to r.
+func CheckCSRF(r *http.Request, sess *Session) bool {
+	token := r.Header.Get("X-CSRF-Token")
+	if token == "" {
+		token = r.FormValue("csrf_token")
+	}
+	return token != "" && token == sess.CSRFToken
+}
+
+// Authenticate validates HTTP Basic credentials against Users and returns
+// the matching record on success.
+func Authenticate(r *http.Request) (string, UserRecord, error) {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return "", UserRecord{}, errors.New("no credentials provided")
+	}
+
+	UsersLock.Lock()
+	record, ok := Users[user]
+	UsersLock.Unlock()
+	if !ok {
+		return "", UserRecord{}, errors.New("unknown user")
+	}
+	if bcrypt.CompareHashAndPassword([]byte(record.PasswordHash), []byte(pass)) != nil {
+		return "", UserRecord{}, errors.New("bad password")
+	}
+	return user, record, nil
+}
+
+// failAuthentication sends a 401 with a WWW-Authenticate challenge, the
+// same way galene's server prompts browsers to pop up a login dialog.
+func failAuthentication(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="recordathon"`)
+	http.Error(w, "Authentication required", http.StatusUnauthorized)
+}
+
+// RequireRole wraps handler so it only runs once the caller has proven they
+// hold at least minRole, either via an existing session cookie or fresh
+// Basic auth credentials (which also starts a session for later requests).
+func RequireRole(minRole Role, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if sess := SessionFromRequest(r); sess != nil {
+			if !roleAtLeast(sess.Role, minRole) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			handler(w, r)
+			return
+		}
+
+		user, record, err := Authenticate(r)
+		if err != nil {
+			failAuthentication(w)
+			return
+		}
+		if !roleAtLeast(record.Role, minRole) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		CreateSession(w, user, record.Role)
+		handler(w, r)
+	}
+}
+
+func roleAtLeast(have, want Role) bool {
+	rank := map[Role]int{RoleViewer: 0, RoleUser: 1, RoleAdmin: 2}
+	return rank[have] >= rank[want]
+}
+
+// csrfTokenFor returns the CSRF token to embed in a rendered page for r, or
+// the empty string if r isn't associated with a session. Templates are
+// expected to drop the result into a hidden "csrf_token" form field (or have
+// their JS echo it back as an X-CSRF-Token header) on every request that
+// reaches CheckCSRF; HandleEdit and HandleHome already pass it into their
+// mustache context under the "csrfToken" key. This repo's assets/ directory
+// (add.html, edit.mustache, index.mustache) isn't part of this source tree,
+// so that wiring can't be verified here — it has to happen on the template
+// side when assets/ is present.
+func csrfTokenFor(r *http.Request) string {
+	sess := SessionFromRequest(r)
+	if sess == nil {
+		return ""
+	}
+	return sess.CSRFToken
+}
+
+// isOwnerOrAdmin reports whether the caller behind r may modify a recording
+// owned by owner.
+func isOwnerOrAdmin(r *http.Request, owner string) bool {
+	sess := SessionFromRequest(r)
+	if sess == nil {
+		return false
+	}
+	return sess.Role == RoleAdmin || sess.User == owner
+}
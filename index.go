@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const peaksResolution = 1000
+
+// maxChunkSize caps how much a single RIFF chunk header is trusted to
+// allocate. Recordings are uploaded audio, not arbitrary files, so a chunk
+// claiming to be hundreds of megabytes is corrupt (or hostile) input, and
+// the indexer re-parses every recording on every pass.
+const maxChunkSize = 256 * 1024 * 1024
+
+// WavInfo is the handful of facts we can pull out of a WAV header without
+// decoding the whole file.
+type WavInfo struct {
+	SampleRate    int
+	Channels      int
+	BitsPerSample int
+	Duration      float64
+}
+
+// IndexEntry is what the background indexer knows about one recording.
+type IndexEntry struct {
+	Name       string   `json:"name"`
+	Duration   float64  `json:"duration"`
+	SampleRate int      `json:"sampleRate"`
+	Channels   int      `json:"channels"`
+	Size       int64    `json:"size"`
+	Tags       []string `json:"tags"`
+	Notes      string   `json:"notes"`
+}
+
+var recordingIndex sync.Map // name -> IndexEntry
+
+// StartIndexer kicks off a goroutine that rebuilds the waveform/peaks index
+// every interval, the same polling pattern gohttpserver's makeIndex loop
+// uses to keep its directory listing cache warm.
+func StartIndexer(interval time.Duration) {
+	go func() {
+		for {
+			if err := reindexAll(); err != nil {
+				log.Print("Indexer pass failed: ", err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func reindexAll() error {
+	names, err := CurrentStorage.List()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := indexOne(name); err != nil {
+			log.Print("Failed to index ", name, ": ", err)
+		}
+	}
+	return nil
+}
+
+// indexOne reads name's WAV header, (re)writes its downsampled peaks file,
+// and refreshes its entry in recordingIndex.
+func indexOne(name string) error {
+	reader, err := CurrentStorage.Get(name)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+
+	wavInfo, samples, err := readWavPeaks(bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+
+	if err := writePeaksFile(name, samples); err != nil {
+		return err
+	}
+
+	cut, _, _ := CurrentMeta.Get(name)
+	recordingIndex.Store(name, IndexEntry{
+		Name:       name,
+		Duration:   wavInfo.Duration,
+		SampleRate: wavInfo.SampleRate,
+		Channels:   wavInfo.Channels,
+		Size:       int64(len(content)),
+		Tags:       cut.Tags,
+		Notes:      cut.Notes,
+	})
+	return nil
+}
+
+// PeakPair is one min/max sample pair covering a downsampled window.
+type PeakPair struct {
+	Min int16 `json:"min"`
+	Max int16 `json:"max"`
+}
+
+// readWavPeaks parses a PCM WAV file's fmt/data chunks and downsamples the
+// first channel into peaksResolution min/max pairs.
+func readWavPeaks(r io.Reader) (WavInfo, []PeakPair, error) {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return WavInfo{}, nil, err
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return WavInfo{}, nil, fmt.Errorf("not a RIFF/WAVE file")
+	}
+
+	var info WavInfo
+	var data []byte
+	for {
+		var chunkHeader [8]byte
+		if _, err := io.ReadFull(r, chunkHeader[:]); err != nil {
+			break
+		}
+		chunkID := string(chunkHeader[0:4])
+		chunkSize := binary.LittleEndian.Uint32(chunkHeader[4:8])
+		if chunkSize > maxChunkSize {
+			return WavInfo{}, nil, fmt.Errorf("%s chunk too large: %d bytes", chunkID, chunkSize)
+		}
+
+		body := make([]byte, chunkSize)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return WavInfo{}, nil, err
+		}
+		if chunkSize%2 == 1 {
+			io.CopyN(ioutil.Discard, r, 1)
+		}
+
+		switch chunkID {
+		case "fmt ":
+			if len(body) < 16 {
+				return WavInfo{}, nil, fmt.Errorf("short fmt chunk")
+			}
+			info.Channels = int(binary.LittleEndian.Uint16(body[2:4]))
+			info.SampleRate = int(binary.LittleEndian.Uint32(body[4:8]))
+			info.BitsPerSample = int(binary.LittleEndian.Uint16(body[14:16]))
+		case "data":
+			data = body
+		}
+	}
+
+	if info.SampleRate == 0 || info.Channels == 0 || info.BitsPerSample == 0 {
+		return WavInfo{}, nil, fmt.Errorf("missing fmt chunk")
+	}
+	bytesPerSample := info.BitsPerSample / 8
+	frameSize := bytesPerSample * info.Channels
+	if frameSize == 0 {
+		return WavInfo{}, nil, fmt.Errorf("invalid frame size")
+	}
+	frameCount := len(data) / frameSize
+	info.Duration = float64(frameCount) / float64(info.SampleRate)
+
+	return info, downsamplePeaks(data, frameSize, bytesPerSample, frameCount), nil
+}
+
+// downsamplePeaks buckets the first channel of PCM16 audio into
+// peaksResolution min/max pairs.
+func downsamplePeaks(data []byte, frameSize, bytesPerSample, frameCount int) []PeakPair {
+	if frameCount == 0 || bytesPerSample != 2 {
+		return nil
+	}
+	buckets := peaksResolution
+	if frameCount < buckets {
+		buckets = frameCount
+	}
+	peaks := make([]PeakPair, buckets)
+	framesPerBucket := frameCount / buckets
+	if framesPerBucket == 0 {
+		framesPerBucket = 1
+	}
+
+	for b := 0; b < buckets; b++ {
+		startFrame := b * framesPerBucket
+		endFrame := startFrame + framesPerBucket
+		if b == buckets-1 {
+			endFrame = frameCount
+		}
+		min, max := int16(0), int16(0)
+		first := true
+		for frame := startFrame; frame < endFrame; frame++ {
+			offset := frame * frameSize
+			sample := int16(binary.LittleEndian.Uint16(data[offset : offset+2]))
+			if first {
+				min, max = sample, sample
+				first = false
+				continue
+			}
+			if sample < min {
+				min = sample
+			}
+			if sample > max {
+				max = sample
+			}
+		}
+		peaks[b] = PeakPair{Min: min, Max: max}
+	}
+	return peaks
+}
+
+func peaksPath(name string) string {
+	return filepath.Join(RootPath, name+".peaks.json")
+}
+
+func writePeaksFile(name string, peaks []PeakPair) error {
+	content, err := json.Marshal(peaks)
+	if err != nil {
+		return err
+	}
+	tmp := peaksPath(name) + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, os.FileMode(0777)); err != nil {
+		return err
+	}
+	return os.Rename(tmp, peaksPath(name))
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"flag"
 	"github.com/hoisie/mustache"
 	"io/ioutil"
 	"log"
@@ -13,6 +15,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 var AssetsPath string
@@ -21,9 +24,21 @@ var CutsPath string
 var Cuts map[string]CutData = map[string]CutData{}
 var GlobalLock sync.Mutex
 
+var CurrentStorage Storage
+var CurrentMeta MetaStore
+
+var S3Bucket string
+var S3Prefix string
+var WebDAVURL string
+var WebDAVUser string
+var WebDAVPassword string
+
 type CutData struct {
-	Start float64 `json:"start"`
-	End   float64 `json:"end"`
+	Start float64  `json:"start"`
+	End   float64  `json:"end"`
+	Owner string   `json:"owner"`
+	Tags  []string `json:"tags"`
+	Notes string   `json:"notes"`
 }
 
 type UploadData struct {
@@ -33,33 +48,60 @@ type UploadData struct {
 }
 
 func main() {
-	if len(os.Args) != 3 {
-		log.Fatal("Usage: ", os.Args[0], " <port> <root path>")
+	storageKind := flag.String("storage", "local", "storage backend: local, s3, or webdav")
+	flag.StringVar(&S3Bucket, "s3-bucket", "", "bucket name for -storage=s3")
+	flag.StringVar(&S3Prefix, "s3-prefix", "", "key prefix for -storage=s3")
+	flag.StringVar(&WebDAVURL, "webdav-url", "", "server URL for -storage=webdav")
+	flag.StringVar(&WebDAVUser, "webdav-user", "", "username for -storage=webdav")
+	flag.StringVar(&WebDAVPassword, "webdav-password", "", "password for -storage=webdav")
+	flag.StringVar(&FFmpegPath, "ffmpeg", "ffmpeg", "path to the ffmpeg binary used by /render")
+	cacheSizeMB := flag.Int64("cache-size-mb", 512, "max size of the rendered-cut cache under .cache")
+	indexInterval := flag.Duration("index-interval", 5*time.Minute, "how often the background indexer rescans recordings")
+	flag.Parse()
+	CacheSizeBytes = *cacheSizeMB * 1024 * 1024
+	args := flag.Args()
+	if len(args) != 2 {
+		log.Fatal("Usage: ", os.Args[0], " [flags] <port> <root path>")
 	}
 
 	// Setup configuration
 	var err error
 	_, filePath, _, _ := runtime.Caller(0)
 	AssetsPath = filepath.Join(filepath.Dir(filePath), "assets")
-	RootPath, err = filepath.Abs(os.Args[2])
+	RootPath, err = filepath.Abs(args[1])
 	if err != nil {
 		log.Fatal("Failed to get absolute root path: ", err)
 	}
+	CurrentStorage = NewStorage(*storageKind)
+	CurrentMeta = &LocalMetaStore{Root: RootPath}
+
+	// Older installs kept all cut metadata in one cuts.json; fold those
+	// entries into the new per-recording sidecar files so nothing is lost.
 	CutsPath = filepath.Join(RootPath, "cuts.json")
 	if content, err := ioutil.ReadFile(CutsPath); err == nil {
 		json.Unmarshal(content, &Cuts)
+		migrateLegacyCuts(CurrentMeta, Cuts)
 	}
+	UsersPath = filepath.Join(RootPath, "users.json")
+	LoadUsers()
 
 	// Setup server
-	if _, err := strconv.Atoi(os.Args[1]); err != nil {
-		log.Fatal("Invalid port number: ", os.Args[1])
-	}
-	http.HandleFunc("/upload", HandleUpload)
-	http.HandleFunc("/add", HandleAdd)
-	http.HandleFunc("/delete/", HandleDelete)
-	http.HandleFunc("/edit/", HandleEdit)
-	http.HandleFunc("/", HandleHome)
-	if err := http.ListenAndServe(":"+os.Args[1], nil); err != nil {
+	if _, err := strconv.Atoi(args[0]); err != nil {
+		log.Fatal("Invalid port number: ", args[0])
+	}
+	http.HandleFunc("/upload", RequireRole(RoleUser, HandleUpload))
+	http.HandleFunc("/upload/stream", RequireRole(RoleUser, HandleUploadStream))
+	http.HandleFunc("/add", RequireRole(RoleUser, HandleAdd))
+	http.HandleFunc("/delete/", RequireRole(RoleUser, HandleDelete))
+	http.HandleFunc("/edit/", RequireRole(RoleUser, HandleEdit))
+	http.HandleFunc("/render/", RequireRole(RoleViewer, HandleRender))
+	http.HandleFunc("/download/all.zip", RequireRole(RoleViewer, HandleDownloadAll))
+	http.HandleFunc("/peaks/", RequireRole(RoleViewer, HandlePeaks))
+	http.HandleFunc("/api/list", RequireRole(RoleViewer, HandleAPIList))
+	http.HandleFunc("/api/meta/", RequireRole(RoleUser, HandleAPIMeta))
+	http.HandleFunc("/", RequireRole(RoleViewer, HandleHome))
+	StartIndexer(*indexInterval)
+	if err := http.ListenAndServe(":"+args[0], nil); err != nil {
 		log.Fatal("Error listening: ", err)
 	}
 }
@@ -70,38 +112,67 @@ func HandleAdd(w http.ResponseWriter, r *http.Request) {
 }
 
 func HandleDelete(w http.ResponseWriter, r *http.Request) {
-	path := strings.Replace(r.URL.Path, "..", "", -1)
-	name := strings.Replace(path[8:], "/", "", -1)
+	name := strings.TrimPrefix(r.URL.Path, "/delete/")
+	if err := validateName(name); err != nil {
+		http.NotFound(w, r)
+		return
+	}
 	log.Print("Serving delete page: ", name)
 
-	GlobalLock.Lock()
-	defer GlobalLock.Unlock()
+	lock := lockForName(name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	cutData, ok, err := CurrentMeta.Get(name)
+	if err != nil || !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !isOwnerOrAdmin(r, cutData.Owner) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if sess := SessionFromRequest(r); sess == nil || !CheckCSRF(r, sess) {
+		http.Error(w, "Bad CSRF token", http.StatusForbidden)
+		return
+	}
 
-	delete(Cuts, name)
-	SaveCuts()
-	if os.Remove(filepath.Join(RootPath, name+".wav")) != nil {
+	CurrentMeta.Delete(name)
+	if CurrentStorage.Delete(name) != nil {
 		http.NotFound(w, r)
 		return
 	}
+	os.Remove(peaksPath(name))
+	recordingIndex.Delete(name)
 
 	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 }
 
 func HandleEdit(w http.ResponseWriter, r *http.Request) {
-	path := strings.Replace(r.URL.Path, "..", "", -1)
-	name := strings.Replace(path[6:], "/", "", -1)
+	name := strings.TrimPrefix(r.URL.Path, "/edit/")
+	if err := validateName(name); err != nil {
+		http.NotFound(w, r)
+		return
+	}
 	log.Print("Serving edit page: ", name)
 
-	GlobalLock.Lock()
-	defer GlobalLock.Unlock()
+	lock := lockForName(name)
+	lock.RLock()
+	defer lock.RUnlock()
 
-	cropInfo, ok := Cuts[name]
-	if !ok {
+	cropInfo, ok, err := CurrentMeta.Get(name)
+	if err != nil || !ok {
 		http.NotFound(w, r)
 		return
 	}
 
-	wavData, err := ioutil.ReadFile(filepath.Join(RootPath, name+".wav"))
+	wavReader, err := CurrentStorage.Get(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer wavReader.Close()
+	wavData, err := ioutil.ReadAll(wavReader)
 	if err != nil {
 		http.NotFound(w, r)
 		return
@@ -109,7 +180,8 @@ func HandleEdit(w http.ResponseWriter, r *http.Request) {
 	wavBase64 := base64.StdEncoding.EncodeToString(wavData)
 
 	info := map[string]interface{}{"name": name, "data": wavBase64,
-		"start": cropInfo.Start, "end": cropInfo.End}
+		"start": cropInfo.Start, "end": cropInfo.End, "csrfToken": csrfTokenFor(r),
+		"tags": cropInfo.Tags, "notes": cropInfo.Notes}
 	templatePath := filepath.Join(AssetsPath, "edit.mustache")
 	body := mustache.RenderFile(templatePath, info)
 	w.Header().Set("Content-Type", "text/html")
@@ -130,7 +202,7 @@ func HandleHome(w http.ResponseWriter, r *http.Request) {
 	}
 
 	info := map[string]interface{}{"files": listing,
-		"fileCount": len(listing)}
+		"fileCount": len(listing), "csrfToken": csrfTokenFor(r)}
 	templatePath := filepath.Join(AssetsPath, "index.mustache")
 	body := mustache.RenderFile(templatePath, info)
 	w.Header().Set("Content-Type", "text/html")
@@ -138,11 +210,13 @@ func HandleHome(w http.ResponseWriter, r *http.Request) {
 }
 
 func HandleOther(w http.ResponseWriter, r *http.Request) {
-	// I'm not really sure why I care about security in such a small project,
-	// but I might as well sanitize the path a bit.
-	cleaned := strings.Replace(r.URL.Path, "..", "", -1)
-	log.Print("Serving static: ", cleaned)
-	http.ServeFile(w, r, filepath.Join(AssetsPath, cleaned))
+	staticPath, err := safeStaticPath(AssetsPath, r.URL.Path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	log.Print("Serving static: ", r.URL.Path)
+	http.ServeFile(w, r, staticPath)
 }
 
 func HandleUpload(w http.ResponseWriter, r *http.Request) {
@@ -172,24 +246,39 @@ func HandleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Once again, security is not *really* a concern, but I'll sanitize the
-	// name anyway.
-	name := strings.Replace(data.Name, "/", "", -1)
-	name = strings.Replace(name, ".", "", -1)
+	name := data.Name
+	if err := validateName(name); err != nil {
+		log.Print("Got invalid recording name: ", name)
+		w.Write([]byte("false"))
+		return
+	}
 
-	GlobalLock.Lock()
-	defer GlobalLock.Unlock()
+	sess := SessionFromRequest(r)
+	if sess == nil || !CheckCSRF(r, sess) {
+		w.Write([]byte("false"))
+		return
+	}
 
-	// Save audio file
-	localPath := filepath.Join(RootPath, name+".wav")
-	err = ioutil.WriteFile(localPath, contents, os.FileMode(0777))
-	if err != nil {
+	lock := lockForName(name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if existing, ok, err := CurrentMeta.Get(name); err == nil && ok && !isOwnerOrAdmin(r, existing.Owner) {
+		w.Write([]byte("false"))
+		return
+	}
+
+	// Kept around for older clients; new uploads should hit /upload/stream
+	// instead of base64-encoding the whole file into a JSON blob. Under the
+	// hood this still goes through the same Storage/MetaStore pair.
+	if err := CurrentStorage.Put(name, bytes.NewReader(contents)); err != nil {
 		log.Fatal("Failed to save uploaded file: ", err)
 	}
 
-	// Save cuts data
-	Cuts[name] = data.Cut
-	SaveCuts()
+	// Save cuts data, tagging it with whoever is uploading.
+	cut := data.Cut
+	cut.Owner = sess.User
+	CurrentMeta.Set(name, cut)
 
 	w.Write([]byte("true"))
 }
@@ -198,33 +287,5 @@ func ReadListing() ([]string, error) {
 	GlobalLock.Lock()
 	defer GlobalLock.Unlock()
 
-	// Read the directory
-	f, err := os.Open(RootPath)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-	names, err := f.Readdirnames(-1)
-	if err != nil {
-		return nil, err
-	}
-	res := make([]string, 0, len(names))
-	for _, name := range names {
-		if strings.HasSuffix(name, ".wav") {
-			res = append(res, name[0:len(name)-4])
-		}
-	}
-
-	return res, nil
-}
-
-func SaveCuts() {
-	cutsData, err := json.Marshal(Cuts)
-	if err != nil {
-		log.Fatal("Failed to marshal cut data: ", err)
-	}
-	err = ioutil.WriteFile(CutsPath, cutsData, os.FileMode(0777))
-	if err != nil {
-		log.Fatal("Failed to save cuts.json: ", err)
-	}
+	return CurrentStorage.List()
 }
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// MetaStore persists CutData, keyed by recording name. Splitting this out
+// from Storage means metadata can live right next to the audio it describes
+// (a per-recording sidecar file) instead of one global cuts.json that gets
+// rewritten on every single upload.
+type MetaStore interface {
+	Get(name string) (CutData, bool, error)
+	Set(name string, data CutData) error
+	Delete(name string) error
+	List() ([]string, error)
+}
+
+// LocalMetaStore keeps one "<name>.json" sidecar per recording, alongside
+// the audio, under Root.
+type LocalMetaStore struct {
+	Root string
+}
+
+func (l *LocalMetaStore) sidecarPath(name string) (string, error) {
+	return safeJoin(l.Root, name, ".json")
+}
+
+func (l *LocalMetaStore) Get(name string) (CutData, bool, error) {
+	path, err := l.sidecarPath(name)
+	if err != nil {
+		return CutData{}, false, err
+	}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return CutData{}, false, nil
+	}
+	if err != nil {
+		return CutData{}, false, err
+	}
+	var data CutData
+	if err := json.Unmarshal(content, &data); err != nil {
+		return CutData{}, false, err
+	}
+	return data, true, nil
+}
+
+func (l *LocalMetaStore) Set(name string, data CutData) error {
+	content, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	dest, err := l.sidecarPath(name)
+	if err != nil {
+		return err
+	}
+	// Write to a temp file and rename into place so a crash mid-write can't
+	// leave a truncated sidecar behind.
+	tmp := dest + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, os.FileMode(0777)); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+func (l *LocalMetaStore) Delete(name string) error {
+	path, err := l.sidecarPath(name)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (l *LocalMetaStore) List() ([]string, error) {
+	f, err := os.Open(l.Root)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0, len(names))
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".peaks.json") {
+			continue
+		}
+		if name == "cuts.json" || name == "users.json" {
+			continue
+		}
+		res = append(res, name[0:len(name)-len(".json")])
+	}
+	return res, nil
+}
+
+// migrateLegacyCuts copies any entries still sitting in the old global
+// cuts.json into store, so upgrading doesn't lose existing metadata.
+func migrateLegacyCuts(store MetaStore, legacy map[string]CutData) {
+	for name, data := range legacy {
+		if _, ok, _ := store.Get(name); !ok {
+			store.Set(name, data)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var validNamePattern = regexp.MustCompile(`^[A-Za-z0-9_\-]{1,128}$`)
+
+// validateName rejects anything that isn't a bare recording name: no
+// slashes, no "..", no trailing extension. strings.Replace(path, "..", "",
+// -1) used to do this job, but "....//" collapses back into "../" once the
+// replace runs, so a crafted name could still escape RootPath.
+func validateName(name string) error {
+	if !validNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid recording name: %q", name)
+	}
+	return nil
+}
+
+// safeJoin validates name, then joins it onto root and confirms the
+// resolved path (following symlinks) still lives under root.
+func safeJoin(root, name, suffix string) (string, error) {
+	if err := validateName(name); err != nil {
+		return "", err
+	}
+	joined := filepath.Clean(filepath.Join(root, name+suffix))
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	resolvedDir, err := filepath.EvalSymlinks(filepath.Dir(joined))
+	if err != nil {
+		// The file may not exist yet (e.g. a new upload); fall back to
+		// checking the unresolved parent against the resolved root.
+		resolvedDir = filepath.Dir(joined)
+	}
+	if resolvedDir != resolvedRoot && !strings.HasPrefix(resolvedDir+string(filepath.Separator), resolvedRoot+string(filepath.Separator)) {
+		return "", fmt.Errorf("%q escapes root path", name)
+	}
+	return joined, nil
+}
+
+// safeStaticPath resolves a request path onto root for serving static
+// assets, rejecting anything that (after symlink resolution) doesn't stay
+// under root.
+func safeStaticPath(root, requestPath string) (string, error) {
+	cleaned := filepath.Clean("/" + requestPath)
+	joined := filepath.Join(root, cleaned)
+
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(joined)
+	if err != nil {
+		// Fall back to checking the parent directory if the file itself
+		// can't be resolved (e.g. it doesn't exist).
+		resolved, err = filepath.EvalSymlinks(filepath.Dir(joined))
+		if err != nil {
+			return "", err
+		}
+	}
+	if resolved != resolvedRoot && !strings.HasPrefix(resolved+string(filepath.Separator), resolvedRoot+string(filepath.Separator)) {
+		return "", fmt.Errorf("%q escapes root path", requestPath)
+	}
+	return joined, nil
+}
+
+// recordingLocks hands out a per-name RWMutex so concurrent uploads/edits
+// of different recordings don't serialize behind one GlobalLock.
+var recordingLocks sync.Map // name -> *sync.RWMutex
+
+func lockForName(name string) *sync.RWMutex {
+	value, _ := recordingLocks.LoadOrStore(name, &sync.RWMutex{})
+	return value.(*sync.RWMutex)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var FFmpegPath string
+var CacheSizeBytes int64
+
+var renderFormats = map[string]string{
+	"wav":  "audio/wav",
+	"mp3":  "audio/mpeg",
+	"ogg":  "audio/ogg",
+	"flac": "audio/flac",
+}
+
+func cacheDir() string {
+	return filepath.Join(RootPath, ".cache")
+}
+
+// cacheKey names a rendered cut so it can be reused across requests as long
+// as the source recording's content (identified by sourceHash) hasn't
+// changed since.
+func cacheKey(name string, cut CutData, format string, sourceHash string) string {
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s|%f|%f|%s|%s", name, cut.Start, cut.End, format, sourceHash)))
+	return fmt.Sprintf("%x.%s", sum, format)
+}
+
+// fetchSource pulls name's audio from CurrentStorage (local disk, S3,
+// WebDAV, whatever is configured) into a local file ffmpeg can operate on,
+// caching it under .cache by content hash so repeated renders of the same
+// recording don't re-fetch it from a remote backend every time.
+func fetchSource(name string) (path string, hash string, err error) {
+	reader, err := CurrentStorage.Get(name)
+	if err != nil {
+		return "", "", err
+	}
+	defer reader.Close()
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return "", "", err
+	}
+
+	sum := sha1.Sum(content)
+	hash = fmt.Sprintf("%x", sum)
+	if err := os.MkdirAll(cacheDir(), os.FileMode(0777)); err != nil {
+		return "", "", err
+	}
+	path = filepath.Join(cacheDir(), "src-"+hash+".wav")
+	if _, statErr := os.Stat(path); statErr != nil {
+		tmp := path + ".tmp"
+		if err := ioutil.WriteFile(tmp, content, os.FileMode(0777)); err != nil {
+			return "", "", err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return "", "", err
+		}
+	}
+	touchCache(path)
+	return path, hash, nil
+}
+
+// HandleRender trims name's source audio to its stored cut and transcodes
+// it to the requested format via ffmpeg, caching the result under
+// RootPath/.cache keyed by (name, start, end, format, source mtime).
+func HandleRender(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/render/")
+	if err := validateName(name); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "wav"
+	}
+	contentType, ok := renderFormats[format]
+	if !ok {
+		http.Error(w, "Unsupported format: "+format, http.StatusBadRequest)
+		return
+	}
+
+	lock := lockForName(name)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	cut, ok, err := CurrentMeta.Get(name)
+	if err != nil || !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	sourcePath, hash, err := fetchSource(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	key := cacheKey(name, cut, format, hash)
+	cachePath := filepath.Join(cacheDir(), key)
+	if _, err := os.Stat(cachePath); err != nil {
+		if err := renderCut(sourcePath, cachePath, cut, format); err != nil {
+			log.Print("ffmpeg render failed: ", err)
+			http.Error(w, "Render failed", http.StatusInternalServerError)
+			return
+		}
+		touchCache(cachePath)
+		evictCacheIfNeeded()
+	} else {
+		touchCache(cachePath)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, format))
+	http.ServeFile(w, r, cachePath)
+}
+
+// renderCut shells out to ffmpeg to trim source to cut.Start..cut.End and
+// transcode to format, writing the result to dest.
+func renderCut(source, dest string, cut CutData, format string) error {
+	if err := os.MkdirAll(cacheDir(), os.FileMode(0777)); err != nil {
+		return err
+	}
+	ffmpeg := FFmpegPath
+	if ffmpeg == "" {
+		ffmpeg = "ffmpeg"
+	}
+	tmpDest := dest + ".tmp"
+	cmd := exec.Command(ffmpeg,
+		"-y",
+		"-i", source,
+		"-ss", strconv.FormatFloat(cut.Start, 'f', -1, 64),
+		"-to", strconv.FormatFloat(cut.End, 'f', -1, 64),
+		tmpDest,
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(tmpDest)
+		return fmt.Errorf("ffmpeg: %v: %s", err, output)
+	}
+	return os.Rename(tmpDest, dest)
+}
+
+// touchCache bumps a cached render's mtime so the LRU eviction sees it as
+// recently used.
+func touchCache(path string) {
+	now := time.Now()
+	os.Chtimes(path, now, now)
+}
+
+// evictCacheIfNeeded deletes the least-recently-touched cached renders
+// until the cache directory is back under CacheSizeBytes.
+func evictCacheIfNeeded() {
+	if CacheSizeBytes <= 0 {
+		return
+	}
+	entries, err := ioutil.ReadDir(cacheDir())
+	if err != nil {
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ModTime().Before(entries[j].ModTime())
+	})
+
+	var total int64
+	for _, e := range entries {
+		total += e.Size()
+	}
+	for _, e := range entries {
+		if total <= CacheSizeBytes {
+			break
+		}
+		if os.Remove(filepath.Join(cacheDir(), e.Name())) == nil {
+			total -= e.Size()
+		}
+	}
+}
+
+// HandleDownloadAll streams a zip archive of every recording's current cut,
+// rendered to WAV, without buffering the whole archive in memory.
+func HandleDownloadAll(w http.ResponseWriter, r *http.Request) {
+	names, err := CurrentMeta.List()
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="recordings.zip"`)
+
+	zw := zip.NewWriter(w)
+	defer zw.Close()
+
+	for _, name := range names {
+		cut, ok, err := CurrentMeta.Get(name)
+		if err != nil || !ok {
+			continue
+		}
+		sourcePath, hash, err := fetchSource(name)
+		if err != nil {
+			continue
+		}
+
+		cachePath := filepath.Join(cacheDir(), cacheKey(name, cut, "wav", hash))
+		if _, err := os.Stat(cachePath); err != nil {
+			if err := renderCut(sourcePath, cachePath, cut, "wav"); err != nil {
+				log.Print("ffmpeg render failed for zip entry ", name, ": ", err)
+				continue
+			}
+		}
+
+		entryWriter, err := zw.Create(name + ".wav")
+		if err != nil {
+			continue
+		}
+		f, err := os.Open(cachePath)
+		if err != nil {
+			continue
+		}
+		io.Copy(entryWriter, f)
+		f.Close()
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/studio-b12/gowebdav"
+)
+
+// Storage abstracts over where recording audio actually lives, so the
+// handlers don't need to know whether they're talking to the local disk,
+// S3, or a WebDAV server.
+type Storage interface {
+	Put(name string, r io.Reader) error
+	Get(name string) (io.ReadCloser, error)
+	Delete(name string) error
+	List() ([]string, error)
+}
+
+// NewStorage builds a Storage for kind ("local", "s3", or "webdav"), reading
+// whatever extra configuration that backend needs from flags.
+func NewStorage(kind string) Storage {
+	switch kind {
+	case "", "local":
+		return &LocalFS{Root: RootPath}
+	case "s3":
+		return NewS3Storage(S3Bucket, S3Prefix)
+	case "webdav":
+		return NewWebDAVStorage(WebDAVURL, WebDAVUser, WebDAVPassword)
+	default:
+		log.Fatal("Unknown -storage backend: ", kind)
+		return nil
+	}
+}
+
+// LocalFS stores recordings directly as files on disk, matching the
+// behavior recordathon has always had.
+type LocalFS struct {
+	Root string
+}
+
+func (l *LocalFS) Put(name string, r io.Reader) error {
+	path, err := l.path(name)
+	if err != nil {
+		return err
+	}
+	// Write to a temp file and rename into place so a reader never observes
+	// a truncated/partial .wav mid-upload.
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(0777))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (l *LocalFS) Get(name string) (io.ReadCloser, error) {
+	path, err := l.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (l *LocalFS) Delete(name string) error {
+	path, err := l.path(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func (l *LocalFS) List() ([]string, error) {
+	f, err := os.Open(l.Root)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0, len(names))
+	for _, name := range names {
+		if strings.HasSuffix(name, ".wav") {
+			res = append(res, name[0:len(name)-4])
+		}
+	}
+	return res, nil
+}
+
+func (l *LocalFS) path(name string) (string, error) {
+	return safeJoin(l.Root, name, ".wav")
+}
+
+// S3Storage stores recordings as objects under a bucket/prefix.
+type S3Storage struct {
+	Bucket string
+	Prefix string
+	client *s3.S3
+}
+
+func NewS3Storage(bucket, prefix string) *S3Storage {
+	sess := session.Must(session.NewSession())
+	return &S3Storage{Bucket: bucket, Prefix: prefix, client: s3.New(sess)}
+}
+
+func (s *S3Storage) key(name string) string {
+	return path.Join(s.Prefix, name+".wav")
+}
+
+func (s *S3Storage) Put(name string, r io.Reader) error {
+	uploader := s3manager.NewUploaderWithClient(s.client)
+	_, err := uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.key(name)),
+		Body:   r,
+	})
+	return err
+}
+
+func (s *S3Storage) Get(name string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (s *S3Storage) Delete(name string) error {
+	_, err := s.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	return err
+}
+
+func (s *S3Storage) List() ([]string, error) {
+	var names []string
+	err := s.client.ListObjectsPages(&s3.ListObjectsInput{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(s.Prefix),
+	}, func(page *s3.ListObjectsOutput, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			key := strings.TrimPrefix(*obj.Key, s.Prefix)
+			key = strings.TrimPrefix(key, "/")
+			if strings.HasSuffix(key, ".wav") {
+				names = append(names, key[0:len(key)-4])
+			}
+		}
+		return true
+	})
+	return names, err
+}
+
+// WebDAVStorage stores recordings on a remote WebDAV server.
+type WebDAVStorage struct {
+	client *gowebdav.Client
+}
+
+func NewWebDAVStorage(url, user, password string) *WebDAVStorage {
+	client := gowebdav.NewClient(url, user, password)
+	return &WebDAVStorage{client: client}
+}
+
+func (w *WebDAVStorage) Put(name string, r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return w.client.Write(name+".wav", data, os.FileMode(0777))
+}
+
+func (w *WebDAVStorage) Get(name string) (io.ReadCloser, error) {
+	data, err := w.client.Read(name + ".wav")
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(strings.NewReader(string(data))), nil
+}
+
+func (w *WebDAVStorage) Delete(name string) error {
+	return w.client.Remove(name + ".wav")
+}
+
+func (w *WebDAVStorage) List() ([]string, error) {
+	infos, err := w.client.ReadDir("")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, info := range infos {
+		if strings.HasSuffix(info.Name(), ".wav") {
+			names = append(names, info.Name()[0:len(info.Name())-4])
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// uploadTempDir holds in-progress streamed uploads so a resumed upload can
+// pick up where a dropped connection left off.
+func uploadTempDir() string {
+	return filepath.Join(RootPath, ".uploads")
+}
+
+// uploadTempPath returns the partial-upload file backing an upload session.
+func uploadTempPath(session string) string {
+	return filepath.Join(uploadTempDir(), session+".part")
+}
+
+// parseContentRange parses a "bytes start-end/total" header, as sent by a
+// client resuming an interrupted upload.
+func parseContentRange(header string) (start, total int64, ok bool) {
+	header = strings.TrimPrefix(header, "bytes ")
+	parts := strings.SplitN(header, "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	rangeParts := strings.SplitN(parts[0], "-", 2)
+	if len(rangeParts) != 2 {
+		return 0, 0, false
+	}
+	startVal, err := strconv.ParseInt(rangeParts[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	totalVal, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return startVal, totalVal, true
+}
+
+// HandleUploadStream accepts a raw PUT of WAV bytes, identified by
+// X-Recording-Name/X-Cut-Start/X-Cut-End headers, and streams it straight to
+// disk instead of buffering a base64 blob in RAM. A client may resume a
+// dropped upload by repeating the request with the same X-Upload-Session and
+// a Content-Range naming only the missing tail.
+func HandleUploadStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Expected PUT", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.Header.Get("X-Recording-Name")
+	if err := validateName(name); err != nil {
+		http.Error(w, "Invalid X-Recording-Name", http.StatusBadRequest)
+		return
+	}
+	start, err := strconv.ParseFloat(r.Header.Get("X-Cut-Start"), 64)
+	if err != nil {
+		http.Error(w, "Invalid X-Cut-Start", http.StatusBadRequest)
+		return
+	}
+	end, err := strconv.ParseFloat(r.Header.Get("X-Cut-End"), 64)
+	if err != nil {
+		http.Error(w, "Invalid X-Cut-End", http.StatusBadRequest)
+		return
+	}
+
+	sess := SessionFromRequest(r)
+	if sess == nil || !CheckCSRF(r, sess) {
+		http.Error(w, "Bad CSRF token", http.StatusForbidden)
+		return
+	}
+	if existing, ok, err := CurrentMeta.Get(name); err == nil && ok && !isOwnerOrAdmin(r, existing.Owner) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	session := r.Header.Get("X-Upload-Session")
+	if session == "" {
+		session = name + "-" + randomToken()
+	} else if err := validateName(session); err != nil {
+		http.Error(w, "Invalid X-Upload-Session", http.StatusBadRequest)
+		return
+	}
+
+	if err := os.MkdirAll(uploadTempDir(), os.FileMode(0777)); err != nil {
+		log.Print("Failed to create upload temp dir: ", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	tempPath := uploadTempPath(session)
+
+	var offset int64
+	total := int64(-1)
+	if rangeHeader := r.Header.Get("Content-Range"); rangeHeader != "" {
+		rangeStart, rangeTotal, ok := parseContentRange(rangeHeader)
+		if !ok {
+			http.Error(w, "Invalid Content-Range", http.StatusBadRequest)
+			return
+		}
+		if info, statErr := os.Stat(tempPath); statErr == nil && info.Size() != rangeStart {
+			w.Header().Set("X-Upload-Offset", strconv.FormatInt(info.Size(), 10))
+			http.Error(w, "Range does not match stored offset", http.StatusConflict)
+			return
+		}
+		offset = rangeStart
+		total = rangeTotal
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	} else {
+		flags |= os.O_APPEND
+	}
+	out, err := os.OpenFile(tempPath, flags, os.FileMode(0777))
+	if err != nil {
+		log.Print("Failed to open upload temp file: ", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	written, err := io.Copy(out, r.Body)
+	out.Close()
+	if err != nil {
+		log.Print("Failed streaming upload body: ", err)
+		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	info, err := os.Stat(tempPath)
+	if err != nil {
+		log.Print("Failed to stat upload temp file: ", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	if total >= 0 && info.Size() < total {
+		// Still missing data; client should resume from here.
+		w.Header().Set("X-Upload-Session", session)
+		w.Header().Set("X-Upload-Offset", strconv.FormatInt(info.Size(), 10))
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "%d", written)
+		return
+	}
+
+	cut := CutData{Start: start, End: end, Owner: sess.User}
+
+	lock := lockForName(name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := finalizeUpload(name, tempPath); err != nil {
+		log.Print("Failed to finalize upload: ", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	CurrentMeta.Set(name, cut)
+
+	w.Write([]byte("true"))
+}
+
+// finalizeUpload hands a completed temp upload off to CurrentStorage,
+// replacing any prior recording of the same name, then cleans up the temp
+// file local to this host.
+func finalizeUpload(name, tempPath string) error {
+	f, err := os.Open(tempPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := CurrentStorage.Put(name, f); err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(tempPath)
+}